@@ -1,29 +1,62 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
 	"github.com/haveachin/infrared"
+	"github.com/pires/go-proxyproto"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
-	"os"
 	"strings"
 )
 
-// APIConfig holds the API configuration including the Bearer token
+// AuthMode selects how authenticateMiddleware validates incoming requests.
+const (
+	AuthModeToken = "token"
+	AuthModeOIDC  = "oidc"
+)
+
+// ctxKeySubject is the context key under which the authenticated subject is stored.
+type ctxKeySubject struct{}
+
+// APIConfig holds the API configuration including the Bearer token and,
+// when AuthMode is "oidc", the OpenID Connect forward-auth settings.
 type APIConfig struct {
 	BearerToken string `json:"bearerToken"`
+
+	AuthMode       string   `json:"authMode"`
+	Issuer         string   `json:"issuer"`
+	Audience       string   `json:"audience"`
+	JWKSURL        string   `json:"jwksUrl"`
+	RequiredScopes []string `json:"requiredScopes"`
+
+	Store         string `json:"store"`
+	RedisAddr     string `json:"redisAddr"`
+	EventsChannel string `json:"eventsChannel"`
+
+	AuditLog string `json:"auditLog"`
+
+	// TrustedProxies lists the IP addresses/CIDR ranges allowed to speak the
+	// PROXY protocol to the API listener. Only connections from one of these
+	// are trusted to carry a real client address, which in turn is the only
+	// case remoteIP (see audit.go) will honor X-Forwarded-For.
+	TrustedProxies []string `json:"trustedProxies"`
 }
 
 var config APIConfig
+var jwks *jwksCache
 
-// authenticateMiddleware checks for valid Bearer token in Authorization header
+// authenticateMiddleware checks for a valid Bearer token or, when AuthMode is
+// "oidc", a valid JWT signed by the configured issuer.
 func authenticateMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
-		
+
 		if authHeader == "" {
 			w.WriteHeader(http.StatusUnauthorized)
 			json.NewEncoder(w).Encode(map[string]string{
@@ -43,6 +76,31 @@ func authenticateMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		}
 
 		token := headerParts[1]
+
+		if config.AuthMode == AuthModeOIDC {
+			claims, err := verifyJWT(token, jwks, config.Issuer, config.Audience)
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Invalid token",
+				})
+				return
+			}
+
+			if requiresWriteScope(r) && !hasRequiredScopes(claims.scopes(), config.RequiredScopes) {
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(map[string]string{
+					"error": "Missing required scope",
+				})
+				return
+			}
+
+			setAuditSubject(r, claims.Subject)
+			ctx := context.WithValue(r.Context(), ctxKeySubject{}, claims.Subject)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
 		if token != config.BearerToken {
 			w.WriteHeader(http.StatusUnauthorized)
 			json.NewEncoder(w).Encode(map[string]string{
@@ -51,10 +109,39 @@ func authenticateMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
+		setAuditSubject(r, "static-token")
 		next.ServeHTTP(w, r)
 	}
 }
 
+// requiresWriteScope reports whether r is a mutating /proxies/{name} or
+// /proxies:bulk request that must be gated behind APIConfig.RequiredScopes.
+func requiresWriteScope(r *http.Request) bool {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		return false
+	}
+	if r.URL.Path == "/proxies:bulk" {
+		return true
+	}
+	return mux.Vars(r)["name"] != ""
+}
+
+func hasRequiredScopes(have, want []string) bool {
+	if len(want) == 0 {
+		return true
+	}
+	haveSet := make(map[string]struct{}, len(have))
+	for _, s := range have {
+		haveSet[s] = struct{}{}
+	}
+	for _, s := range want {
+		if _, ok := haveSet[s]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
 // LoadConfig loads the API configuration from file
 func LoadConfig(configFile string) error {
 	data, err := ioutil.ReadFile(configFile)
@@ -67,10 +154,25 @@ func LoadConfig(configFile string) error {
 		return err
 	}
 
-	if config.BearerToken == "" {
+	if config.AuthMode == AuthModeOIDC {
+		if config.JWKSURL == "" {
+			return fmt.Errorf("jwksUrl not found in config file")
+		}
+		jwks = newJWKSCache(config.JWKSURL)
+	} else if config.BearerToken == "" {
 		return fmt.Errorf("bearer token not found in config file")
 	}
 
+	if config.RedisAddr != "" {
+		redisClient = redis.NewClient(&redis.Options{Addr: config.RedisAddr})
+	}
+
+	sink, err := newAuditSink(config)
+	if err != nil {
+		return err
+	}
+	auditSink = sink
+
 	return nil
 }
 
@@ -82,33 +184,130 @@ func ListenAndServe(configPath string, apiBind string, apiConfigPath string) {
 		log.Fatalf("Failed to load API config: %v", err)
 	}
 
+	store, err := newConfigStore(config, configPath)
+	if err != nil {
+		log.Fatalf("Failed to set up config store: %v", err)
+	}
+	seedFromStore(store)
+	go watchConfigStore(store)
+
 	log.Println("Starting WebAPI on " + apiBind)
 	router := mux.NewRouter()
 
-	// Apply authentication to all routes
-	router.HandleFunc("/", authenticateMiddleware(getHome())).Methods("GET")
-	router.HandleFunc("/proxies", authenticateMiddleware(getProxies(configPath))).Methods("GET")
-	router.HandleFunc("/proxies/{name}", authenticateMiddleware(getProxy(configPath))).Methods("GET")
-	router.HandleFunc("/proxies/{name}", authenticateMiddleware(addProxyWithName(configPath))).Methods("POST")
-	router.HandleFunc("/proxies/{name}", authenticateMiddleware(removeProxy(configPath))).Methods("DELETE")
-
+	// Apply authentication and audit logging to all routes
+	router.HandleFunc("/", auditMiddleware(authenticateMiddleware(getHome()))).Methods("GET")
+	router.HandleFunc("/proxies", auditMiddleware(authenticateMiddleware(getProxies(store)))).Methods("GET")
+	// Registered before /proxies/{name}, which would otherwise match "events" as a name.
+	router.HandleFunc("/proxies/events", auditMiddleware(authenticateMiddleware(getProxyEvents()))).Methods("GET")
+	router.HandleFunc("/proxies/{name}", auditMiddleware(authenticateMiddleware(getProxy(store)))).Methods("GET")
+	router.HandleFunc("/proxies/{name}", auditMiddleware(authenticateMiddleware(addProxyWithName(store)))).Methods("POST")
+	router.HandleFunc("/proxies/{name}", auditMiddleware(authenticateMiddleware(removeProxy(store)))).Methods("DELETE")
+	router.HandleFunc("/proxies/{name}/health", auditMiddleware(authenticateMiddleware(getProxyHealth()))).Methods("GET")
+	router.HandleFunc("/proxies:bulk", auditMiddleware(authenticateMiddleware(bulkImportProxies(store)))).Methods("POST")
+	router.HandleFunc("/audit", auditMiddleware(authenticateMiddleware(getAuditLog()))).Methods("GET")
+
+	server := &http.Server{Handler: router, ConnContext: trustedProxyConnContext}
+
+	var listen net.Listener
 	if infrared.Config.Tableflip.Enabled {
-		listen, err := infrared.Upg.Listen("tcp", apiBind)
-		if err != nil {
-			log.Printf("Failed to start API listener: %s", err)
-			return
+		listen, err = infrared.Upg.Listen("tcp", apiBind)
+	} else {
+		listen, err = net.Listen("tcp", apiBind)
+	}
+	if err != nil {
+		log.Printf("Failed to start API listener: %s", err)
+		return
+	}
+
+	if err := server.Serve(trustedProxyListener(listen)); err != nil {
+		log.Printf("Failed to start serving API: %s", err)
+		return
+	}
+}
+
+// trustedProxyListener wraps listen with the PROXY protocol, trusting a
+// connection's header - and by extension its claimed X-Forwarded-For, see
+// remoteIP in audit.go - only when it comes from APIConfig.TrustedProxies.
+// Any other upstream's header, if sent at all, is ignored.
+func trustedProxyListener(listen net.Listener) net.Listener {
+	return &proxyproto.Listener{
+		Listener: listen,
+		Policy:   proxyproto.MustLaxWhiteListPolicy(config.TrustedProxies),
+	}
+}
+
+// trustedProxyConnContext tags a request's context with whether its
+// connection was accepted as a trusted PROXY protocol upstream, so
+// remoteIP can decide whether to honor X-Forwarded-For.
+func trustedProxyConnContext(ctx context.Context, c net.Conn) context.Context {
+	if pc, ok := c.(*proxyproto.Conn); ok && pc.ProxyHeaderPolicy == proxyproto.USE {
+		return context.WithValue(ctx, ctxKeyTrustedProxy{}, true)
+	}
+	return ctx
+}
+
+// watchConfigStore subscribes to a ConfigStore's Watch channel and reacts to
+// every change regardless of which replica or handler caused it: the local
+// infrared proxy manager is reloaded and the change is fanned out over
+// Redis/SSE via publishProxyChange.
+func watchConfigStore(store ConfigStore) {
+	for event := range store.Watch(context.Background()) {
+		switch event.Op {
+		case "upsert":
+			var cfg infrared.ProxyConfig
+			if err := json.Unmarshal(event.Data, &cfg); err != nil && !isProxyToTypeMismatch(err) {
+				log.Println(err)
+				continue
+			}
+			backends, healthCheck, err := parseBackends(event.Data)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			startHealthChecker(event.Name, backends, healthCheck)
+			publishProxyChange("upsert", event.Name, &cfg)
+		case "delete":
+			stopHealthChecker(event.Name)
+			publishProxyChange("delete", event.Name, nil)
 		}
-		err = http.Serve(listen, router)
+	}
+}
+
+// seedFromStore replays every proxy already present in store into both the
+// infrared proxy registry (infrared.ReloadProxy) and the health checker, so a restart doesn't
+// leave infrared.GetProxyConfig/GET /proxies/{name}/health blank for
+// pre-existing proxies until someone re-PUTs their config (watchConfigStore
+// only reacts to changes from here on).
+func seedFromStore(store ConfigStore) {
+	ctx := context.Background()
+
+	names, err := store.List(ctx)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	for _, name := range names {
+		data, err := store.Get(ctx, name)
 		if err != nil {
-			log.Printf("Failed to start serving API: %s", err)
-			return
+			log.Println(err)
+			continue
 		}
-	} else {
-		err := http.ListenAndServe(apiBind, router)
+
+		var cfg infrared.ProxyConfig
+		if err := json.Unmarshal(data, &cfg); err != nil && !isProxyToTypeMismatch(err) {
+			log.Println(err)
+			continue
+		}
+		infrared.ReloadProxy(name, cfg)
+
+		backends, healthCheck, err := parseBackends(data)
 		if err != nil {
-			log.Printf("Failed to start serving API: %s", err)
-			return
+			log.Println(err)
+			continue
 		}
+
+		startHealthChecker(name, backends, healthCheck)
 	}
 }
 
@@ -118,21 +317,15 @@ func getHome() http.HandlerFunc {
 }
 
 // getProxies
-func getProxies(configPath string) http.HandlerFunc {
+func getProxies(store ConfigStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var configs []string
-
-		files, err := ioutil.ReadDir(configPath)
+		configs, err := store.List(r.Context())
 		if err != nil {
 			log.Println(err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		for _, file := range files {
-			configs = append(configs, strings.Split(file.Name(), ".json")[0])
-		}
-
 		err = json.NewEncoder(w).Encode(&configs)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -142,25 +335,27 @@ func getProxies(configPath string) http.HandlerFunc {
 }
 
 // getProxy
-func getProxy(configPath string) http.HandlerFunc {
+func getProxy(store ConfigStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		fileName := mux.Vars(r)["name"] + ".json"
+		name := mux.Vars(r)["name"]
 
-		jsonFile, err := os.Open(configPath + "/" + fileName)
-		defer jsonFile.Close()
+		data, err := store.Get(r.Context(), name)
 		if err != nil {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
 
-		config, err := ioutil.ReadAll(jsonFile)
-		if err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+		if isYAMLContentType(r.Header.Get("Accept")) {
+			data, err = jsonToYAML(data)
+			if err != nil {
+				log.Println(err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/yaml")
 		}
 
-		_, err = w.Write(config)
+		_, err = w.Write(data)
 		if err != nil {
 			log.Println(err)
 			w.WriteHeader(http.StatusInternalServerError)
@@ -170,9 +365,9 @@ func getProxy(configPath string) http.HandlerFunc {
 }
 
 // addProxyWithName respond to post proxy request
-func addProxyWithName(configPath string) http.HandlerFunc {
+func addProxyWithName(store ConfigStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		fileName := mux.Vars(r)["name"] + ".json"
+		name := mux.Vars(r)["name"]
 
 		rawData, err := ioutil.ReadAll(r.Body)
 		if err != nil || string(rawData) == "" {
@@ -180,7 +375,15 @@ func addProxyWithName(configPath string) http.HandlerFunc {
 			return
 		}
 
-		jsonIsValid := checkJSONAndRegister(rawData, fileName, configPath)
+		if isYAMLContentType(r.Header.Get("Content-Type")) {
+			rawData, err = yamlToJSON(rawData)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+
+		jsonIsValid := checkJSONAndRegister(r.Context(), rawData, name, store)
 		if jsonIsValid {
 			w.WriteHeader(http.StatusOK)
 			w.Write([]byte("{'success': true, 'message': 'the proxy has been added succesfully'}"))
@@ -194,12 +397,11 @@ func addProxyWithName(configPath string) http.HandlerFunc {
 }
 
 // removeProxy respond to delete proxy request
-func removeProxy(configPath string) http.HandlerFunc {
+func removeProxy(store ConfigStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		file := mux.Vars(r)["name"] + ".json"
+		name := mux.Vars(r)["name"]
 
-		err := os.Remove(configPath + "/" + file)
-		if err != nil {
+		if err := store.Delete(r.Context(), name); err != nil {
 			w.WriteHeader(http.StatusNoContent)
 			w.Write([]byte(err.Error()))
 			return
@@ -207,30 +409,31 @@ func removeProxy(configPath string) http.HandlerFunc {
 	}
 }
 
-// checkJSONAndRegister validate proxy configuration
-func checkJSONAndRegister(rawData []byte, filename string, configPath string) (successful bool) {
+// checkJSONAndRegister validates a proxy configuration and, if valid,
+// persists it to store. The proxy manager reload and change notifications
+// happen out-of-band, driven by store.Watch (see watchConfigStore).
+func checkJSONAndRegister(ctx context.Context, rawData []byte, name string, store ConfigStore) (successful bool) {
 	var cfg infrared.ProxyConfig
 	err := json.Unmarshal(rawData, &cfg)
-	if err != nil {
+	if err != nil && !isProxyToTypeMismatch(err) {
+		// A list-style ProxyTo doesn't fit the upstream ProxyConfig.ProxyTo
+		// string field; parseBackends below handles that shape instead.
 		log.Println(err)
 		return false
 	}
 
-	if len(cfg.DomainNames) < 1 || cfg.ProxyTo == "" {
+	backends, _, err := parseBackends(rawData)
+	if err != nil {
+		log.Println(err)
 		return false
 	}
 
-	path := configPath + "/" + filename
-	temppath := path + ".temp"
-
-	err = os.WriteFile(temppath, rawData, 0644)
-	if err != nil {
-		log.Println(err)
+	if len(cfg.DomainNames) < 1 || len(backends) < 1 {
 		return false
 	}
 
-	err = os.Rename(temppath, path)
-	if err != nil {
+	if err := store.Put(ctx, name, rawData); err != nil {
+		log.Println(err)
 		return false
 	}
 
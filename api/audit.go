@@ -0,0 +1,387 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+)
+
+// auditFileRotateSize is the size threshold at which a file audit sink
+// rotates the current log to a ".1" suffix.
+const auditFileRotateSize = 10 * 1024 * 1024 // 10 MiB
+
+// ctxKeyAudit is the context key under which the in-flight audit record is
+// stored so inner middleware (authenticateMiddleware) can enrich it.
+type ctxKeyAudit struct{}
+
+// ctxKeyTrustedProxy is the context key set by trustedProxyConnContext (see
+// api.go) when a request's connection was accepted as a trusted PROXY
+// protocol upstream.
+type ctxKeyTrustedProxy struct{}
+
+// AuditRecord is one structured log line for a mutating (or any) API request.
+type AuditRecord struct {
+	ID         int64     `json:"id"`
+	Timestamp  time.Time `json:"timestamp"`
+	RemoteIP   string    `json:"remoteIp"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	DurationMS int64     `json:"durationMs"`
+	Subject    string    `json:"subject,omitempty"`
+	ProxyName  string    `json:"proxyName,omitempty"`
+	BodySHA256 string    `json:"bodySha256,omitempty"`
+}
+
+// AuditSink persists audit records and serves GET /audit pagination.
+type AuditSink interface {
+	Write(ctx context.Context, record AuditRecord) error
+	Since(ctx context.Context, sinceID int64, limit int) ([]AuditRecord, error)
+}
+
+var auditSink AuditSink = noopAuditSink{}
+
+// newAuditSink builds the sink selected by APIConfig.AuditLog: "stdout"
+// (the default), a file path (rotated by size), or "redis" for a Redis
+// stream.
+func newAuditSink(cfg APIConfig) (AuditSink, error) {
+	switch cfg.AuditLog {
+	case "", "stdout":
+		return newStdoutAuditSink(), nil
+	case "redis":
+		if redisClient == nil {
+			return nil, fmt.Errorf("redisAddr must be set when auditLog is \"redis\"")
+		}
+		return newRedisAuditSink(redisClient, "infrared:audit"), nil
+	default:
+		return newFileAuditSink(cfg.AuditLog)
+	}
+}
+
+// statusWriter wraps http.ResponseWriter to capture the status code written
+// by the handler, defaulting to 200 if WriteHeader was never called.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// auditMiddleware times the request, captures its outcome, and writes an
+// AuditRecord to the configured sink. It must wrap authenticateMiddleware so
+// that the subject it resolves can be attached to the same record.
+func auditMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		record := &AuditRecord{
+			Timestamp: time.Now(),
+			RemoteIP:  remoteIP(r),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Status:    http.StatusOK,
+		}
+
+		if name := mux.Vars(r)["name"]; name != "" && (r.Method == http.MethodPost || r.Method == http.MethodDelete) {
+			record.ProxyName = name
+		}
+
+		if r.Method == http.MethodPost && r.Body != nil {
+			bodyBytes, err := ioutil.ReadAll(r.Body)
+			if err == nil {
+				sum := sha256.Sum256(bodyBytes)
+				record.BodySHA256 = hex.EncodeToString(sum[:])
+				r.Body = ioutil.NopCloser(bytes.NewReader(bodyBytes))
+			}
+		}
+
+		ctx := context.WithValue(r.Context(), ctxKeyAudit{}, record)
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+		start := time.Now()
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		record.Status = sw.status
+		record.DurationMS = time.Since(start).Milliseconds()
+
+		if err := auditSink.Write(context.Background(), *record); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// setAuditSubject attaches the authenticated subject to the in-flight audit
+// record, if one is present on the request context.
+func setAuditSubject(r *http.Request, subject string) {
+	if record, ok := r.Context().Value(ctxKeyAudit{}).(*AuditRecord); ok {
+		record.Subject = subject
+	}
+}
+
+// remoteIP resolves the client address, preferring X-Forwarded-For only when
+// the request's connection was accepted as a trusted go-proxyproto upstream
+// (see APIConfig.TrustedProxies and trustedProxyConnContext in api.go).
+// Any other caller's X-Forwarded-For is forgeable and is ignored.
+func remoteIP(r *http.Request) string {
+	if trusted, _ := r.Context().Value(ctxKeyTrustedProxy{}).(bool); trusted {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	return r.RemoteAddr
+}
+
+// getAuditLog serves GET /audit?since=<id>&limit=<n>, paging through recent
+// audit records.
+func getAuditLog() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var sinceID int64
+		if since := r.URL.Query().Get("since"); since != "" {
+			sinceID, _ = strconv.ParseInt(since, 10, 64)
+		}
+
+		limit := 100
+		if l := r.URL.Query().Get("limit"); l != "" {
+			if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 {
+				limit = parsed
+			}
+		}
+
+		records, err := auditSink.Since(r.Context(), sinceID, limit)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(records)
+	}
+}
+
+// noopAuditSink is used only until LoadConfig has run.
+type noopAuditSink struct{}
+
+func (noopAuditSink) Write(ctx context.Context, record AuditRecord) error { return nil }
+func (noopAuditSink) Since(ctx context.Context, sinceID int64, limit int) ([]AuditRecord, error) {
+	return nil, nil
+}
+
+// stdoutAuditSink writes each record as a JSON line to stdout and keeps a
+// bounded in-memory ring buffer so GET /audit still has something to page.
+type stdoutAuditSink struct {
+	mu      sync.Mutex
+	nextID  int64
+	records []AuditRecord
+}
+
+const stdoutAuditRingSize = 1000
+
+func newStdoutAuditSink() *stdoutAuditSink {
+	return &stdoutAuditSink{}
+}
+
+func (s *stdoutAuditSink) Write(ctx context.Context, record AuditRecord) error {
+	s.mu.Lock()
+	s.nextID++
+	record.ID = s.nextID
+	s.records = append(s.records, record)
+	if len(s.records) > stdoutAuditRingSize {
+		s.records = s.records[len(s.records)-stdoutAuditRingSize:]
+	}
+	s.mu.Unlock()
+
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	log.Println(string(payload))
+	return nil
+}
+
+func (s *stdoutAuditSink) Since(ctx context.Context, sinceID int64, limit int) ([]AuditRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []AuditRecord
+	for _, r := range s.records {
+		if r.ID > sinceID {
+			out = append(out, r)
+			if len(out) >= limit {
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+// fileAuditSink appends JSON lines to a file, rotating it to a ".1" suffix
+// once it grows past auditFileRotateSize.
+type fileAuditSink struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	nextID int64
+}
+
+func newFileAuditSink(path string) (*fileAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileAuditSink{path: path, file: file}, nil
+}
+
+func (s *fileAuditSink) Write(ctx context.Context, record AuditRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	record.ID = s.nextID
+
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	payload = append(payload, '\n')
+
+	if _, err := s.file.Write(payload); err != nil {
+		return err
+	}
+
+	if info, err := s.file.Stat(); err == nil && info.Size() > auditFileRotateSize {
+		return s.rotateLocked()
+	}
+	return nil
+}
+
+func (s *fileAuditSink) rotateLocked() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.file = file
+	return nil
+}
+
+// Since only serves records from the active (post-rotation) file; the
+// audit trail's durability comes from the file itself, not this endpoint.
+func (s *fileAuditSink) Since(ctx context.Context, sinceID int64, limit int) ([]AuditRecord, error) {
+	s.mu.Lock()
+	path := s.path
+	s.mu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []AuditRecord
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var record AuditRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			continue
+		}
+		if record.ID > sinceID {
+			out = append(out, record)
+			if len(out) >= limit {
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+// redisAuditSink appends records to a Redis stream (XADD) and serves Since
+// with XRANGE.
+type redisAuditSink struct {
+	client *redis.Client
+	stream string
+}
+
+func newRedisAuditSink(client *redis.Client, stream string) *redisAuditSink {
+	return &redisAuditSink{client: client, stream: stream}
+}
+
+func (s *redisAuditSink) Write(ctx context.Context, record AuditRecord) error {
+	record.ID = 0 // the stream's own entry ID is the source of truth, not this field
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.stream,
+		Values: map[string]interface{}{"record": payload},
+	}).Err()
+}
+
+// Since returns up to limit records starting just after stream entry ID
+// "<sinceID>-0", or from the beginning when sinceID is 0. sinceID is the
+// millisecond timestamp portion of a Redis stream entry ID, as reported in
+// the "id" field of a returned record; entries sharing that millisecond with
+// a higher sequence number are returned again.
+func (s *redisAuditSink) Since(ctx context.Context, sinceID int64, limit int) ([]AuditRecord, error) {
+	start := "-"
+	if sinceID > 0 {
+		start = fmt.Sprintf("(%d-0", sinceID)
+	}
+
+	entries, err := s.client.XRangeN(ctx, s.stream, start, "+", int64(limit)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]AuditRecord, 0, len(entries))
+	for _, entry := range entries {
+		raw, ok := entry.Values["record"].(string)
+		if !ok {
+			continue
+		}
+		var record AuditRecord
+		if err := json.Unmarshal([]byte(raw), &record); err != nil {
+			continue
+		}
+		record.ID = streamEntryMillis(entry.ID)
+		out = append(out, record)
+	}
+	return out, nil
+}
+
+// streamEntryMillis extracts the millisecond-timestamp portion of a Redis
+// stream entry ID ("<millis>-<seq>"), matching the "(%d-0" cursor format
+// Since builds above. Returns 0 if id doesn't parse, which just means that
+// record can't be used as a ?since= cursor.
+func streamEntryMillis(id string) int64 {
+	millis := id
+	if i := strings.Index(id, "-"); i >= 0 {
+		millis = id[:i]
+	}
+	n, err := strconv.ParseInt(millis, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/haveachin/infrared"
+	"gopkg.in/yaml.v2"
+)
+
+// bulkDocument is the shape accepted by POST /proxies:bulk: a map of proxy
+// name to config, plus optional defaults merged into every entry.
+type bulkDocument struct {
+	Defaults map[string]interface{}            `yaml:"defaults" json:"defaults"`
+	Proxies  map[string]map[string]interface{} `yaml:"proxies" json:"proxies"`
+}
+
+// bulkResult reports the outcome of importing a single entry of a bulk document.
+type bulkResult struct {
+	Name    string `json:"name"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// bulkEntry is a validated proxy pending commit to the store.
+type bulkEntry struct {
+	name    string
+	rawJSON []byte
+}
+
+// isYAMLContentType reports whether a request/response should be treated as
+// YAML based on its Content-Type or Accept header.
+func isYAMLContentType(header string) bool {
+	return header == "application/yaml" || header == "application/x-yaml"
+}
+
+// yamlToJSON normalizes a YAML document to JSON for on-disk storage. yaml.v2
+// decodes nested mappings as map[interface{}]interface{}, which
+// encoding/json cannot marshal, so the decoded value is walked and
+// normalized to JSON-safe types first.
+func yamlToJSON(rawYAML []byte) ([]byte, error) {
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(rawYAML, &doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(normalizeYAML(doc))
+}
+
+// normalizeYAML recursively converts the map[interface{}]interface{} and
+// []interface{} values produced by yaml.v2 into map[string]interface{} and
+// []interface{} of JSON-safe values, leaving everything else untouched.
+func normalizeYAML(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			out[fmt.Sprintf("%v", k)] = normalizeYAML(val)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			out[k] = normalizeYAML(val)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, val := range v {
+			out[i] = normalizeYAML(val)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// jsonToYAML converts stored JSON back to YAML for clients that prefer it.
+func jsonToYAML(rawJSON []byte) ([]byte, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(rawJSON, &doc); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(doc)
+}
+
+// mergeDefaults returns entry with any keys missing from entry filled in from defaults.
+func mergeDefaults(entry, defaults map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(defaults)+len(entry))
+	for k, v := range defaults {
+		merged[k] = v
+	}
+	for k, v := range entry {
+		merged[k] = v
+	}
+	return merged
+}
+
+// bulkImportProxies handles POST /proxies:bulk: it accepts a single YAML or
+// JSON document describing many proxies at once, validates every entry, and
+// only persists any of them if all of them pass validation.
+func bulkImportProxies(store ConfigStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rawData, err := ioutil.ReadAll(r.Body)
+		if err != nil || len(rawData) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var doc bulkDocument
+		if isYAMLContentType(r.Header.Get("Content-Type")) {
+			err = yaml.Unmarshal(rawData, &doc)
+		} else {
+			err = json.Unmarshal(rawData, &doc)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+
+		results := make([]bulkResult, 0, len(doc.Proxies))
+		entries := make([]bulkEntry, 0, len(doc.Proxies))
+		allValid := true
+
+		for name, entry := range doc.Proxies {
+			merged := mergeDefaults(entry, doc.Defaults)
+
+			rawJSON, err := json.Marshal(normalizeYAML(merged))
+			if err != nil {
+				results = append(results, bulkResult{Name: name, Error: err.Error()})
+				allValid = false
+				continue
+			}
+
+			var cfg infrared.ProxyConfig
+			if err := json.Unmarshal(rawJSON, &cfg); err != nil && !isProxyToTypeMismatch(err) {
+				results = append(results, bulkResult{Name: name, Error: err.Error()})
+				allValid = false
+				continue
+			}
+
+			backends, _, err := parseBackends(rawJSON)
+			if err != nil || len(cfg.DomainNames) < 1 || len(backends) < 1 {
+				msg := "domainNames and proxyTo could not be found"
+				if err != nil {
+					msg = err.Error()
+				}
+				results = append(results, bulkResult{Name: name, Error: msg})
+				allValid = false
+				continue
+			}
+
+			results = append(results, bulkResult{Name: name, Success: true})
+			entries = append(entries, bulkEntry{name: name, rawJSON: rawJSON})
+		}
+
+		if !allValid {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(results)
+			return
+		}
+
+		if err := putAll(r.Context(), store, entries); err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+
+		json.NewEncoder(w).Encode(results)
+	}
+}
+
+// putAll commits entries to store as a single all-or-nothing batch when the
+// store supports it (AtomicPutter), falling back to sequential Put calls
+// otherwise.
+func putAll(ctx context.Context, store ConfigStore, entries []bulkEntry) error {
+	if atomic, ok := store.(AtomicPutter); ok {
+		batch := make(map[string][]byte, len(entries))
+		for _, e := range entries {
+			batch[e.name] = e.rawJSON
+		}
+		return atomic.PutAll(ctx, batch)
+	}
+
+	for _, e := range entries {
+		if err := store.Put(ctx, e.name, e.rawJSON); err != nil {
+			return err
+		}
+	}
+	return nil
+}
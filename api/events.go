@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/haveachin/infrared"
+)
+
+// proxyEventRingSize bounds how many events GET /proxies/events can replay
+// to a client that reconnects with a Last-Event-ID.
+const proxyEventRingSize = 256
+
+// ProxyEvent is published whenever a proxy config is added or removed, both
+// over Redis (infrared:proxies by default) and to any connected SSE clients.
+type ProxyEvent struct {
+	ID     int64                 `json:"id"`
+	Op     string                `json:"op"`
+	Name   string                `json:"name"`
+	Config *infrared.ProxyConfig `json:"config,omitempty"`
+}
+
+// eventBus fans ProxyEvents out to SSE subscribers and keeps a small ring
+// buffer so reconnecting clients can resume from Last-Event-ID.
+type eventBus struct {
+	mu          sync.Mutex
+	nextID      int64
+	ring        []ProxyEvent
+	subscribers map[chan ProxyEvent]struct{}
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{
+		subscribers: make(map[chan ProxyEvent]struct{}),
+	}
+}
+
+var events = newEventBus()
+var redisClient *redis.Client
+
+func (b *eventBus) publish(op, name string, cfg *infrared.ProxyConfig) ProxyEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	event := ProxyEvent{ID: b.nextID, Op: op, Name: name, Config: cfg}
+
+	b.ring = append(b.ring, event)
+	if len(b.ring) > proxyEventRingSize {
+		b.ring = b.ring[len(b.ring)-proxyEventRingSize:]
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+
+	return event
+}
+
+func (b *eventBus) subscribe(sinceID int64) (chan ProxyEvent, []ProxyEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var backlog []ProxyEvent
+	for _, e := range b.ring {
+		if e.ID > sinceID {
+			backlog = append(backlog, e)
+		}
+	}
+
+	ch := make(chan ProxyEvent, 16)
+	b.subscribers[ch] = struct{}{}
+	return ch, backlog
+}
+
+func (b *eventBus) unsubscribe(ch chan ProxyEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers, ch)
+	close(ch)
+}
+
+// publishProxyChange notifies the running infrared proxy manager of a config
+// change, both directly (single-node) and over Redis pub/sub (multi-node),
+// and fans the change out to any GET /proxies/events subscribers.
+func publishProxyChange(op, name string, cfg *infrared.ProxyConfig) {
+	switch op {
+	case "upsert":
+		infrared.ReloadProxy(name, *cfg)
+	case "delete":
+		infrared.RemoveProxy(name)
+	}
+
+	event := events.publish(op, name, cfg)
+
+	if redisClient == nil {
+		return
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	channel := config.EventsChannel
+	if channel == "" {
+		channel = "infrared:proxies"
+	}
+
+	if err := redisClient.Publish(context.Background(), channel, payload).Err(); err != nil {
+		log.Println(err)
+	}
+}
+
+// getProxyEvents streams proxy change events as Server-Sent Events, resuming
+// from Last-Event-ID (header or ?since=) when present.
+func getProxyEvents() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var sinceID int64
+		if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+			sinceID, _ = strconv.ParseInt(lastID, 10, 64)
+		} else if since := r.URL.Query().Get("since"); since != "" {
+			sinceID, _ = strconv.ParseInt(since, 10, 64)
+		}
+
+		ch, backlog := events.subscribe(sinceID)
+		defer events.unsubscribe(ch)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+
+		for _, event := range backlog {
+			writeProxyEvent(w, event)
+		}
+		flusher.Flush()
+
+		for {
+			select {
+			case event := <-ch:
+				writeProxyEvent(w, event)
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+func writeProxyEvent(w http.ResponseWriter, event ProxyEvent) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	fmt.Fprintf(w, "id: %d\ndata: %s\n\n", event.ID, payload)
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/haveachin/infrared"
+)
+
+// BackendConfig and HealthCheckConfig are aliases for the infrared package's
+// types: the health checker itself lives there so the connection-handling
+// core can reach PickBackend without importing api (see infrared.PickBackend).
+type BackendConfig = infrared.BackendConfig
+type HealthCheckConfig = infrared.HealthCheckConfig
+
+const (
+	defaultHealthInterval      = 10 * time.Second
+	defaultHealthTimeout       = 5 * time.Second
+	defaultHealthFailThreshold = 3
+	defaultHealthPassThreshold = 2
+
+	// minHealthInterval floors a configured interval so a mistyped value
+	// (e.g. "30ms" where "30s" was meant) can't spin the checker against a
+	// backend essentially continuously.
+	minHealthInterval = time.Second
+)
+
+// backendHealthConfig is the subset of a ProxyConfig relevant to health
+// checking. It is decoded from the same rawData as the infrared.ProxyConfig,
+// since the upstream ProxyConfig struct only knows a single ProxyTo string.
+type backendHealthConfig struct {
+	ProxyTo     json.RawMessage   `json:"proxyTo"`
+	HealthCheck HealthCheckConfig `json:"healthCheck"`
+}
+
+// isProxyToTypeMismatch reports whether err is the *json.UnmarshalTypeError
+// produced when ProxyTo is a list rather than the upstream ProxyConfig's
+// plain string field.
+func isProxyToTypeMismatch(err error) bool {
+	typeErr, ok := err.(*json.UnmarshalTypeError)
+	return ok && typeErr.Field == "proxyTo"
+}
+
+// parseBackends extracts the backend list from a ProxyConfig's raw JSON,
+// accepting ProxyTo as either a single address string or a list of
+// {address, weight, bypassDomains} entries.
+func parseBackends(rawData []byte) ([]BackendConfig, HealthCheckConfig, error) {
+	var cfg backendHealthConfig
+	if err := json.Unmarshal(rawData, &cfg); err != nil {
+		return nil, HealthCheckConfig{}, err
+	}
+
+	hc := cfg.HealthCheck
+	if hc.Interval == 0 {
+		hc.Interval = infrared.Duration(defaultHealthInterval)
+	} else if hc.Interval.Duration() < minHealthInterval {
+		hc.Interval = infrared.Duration(minHealthInterval)
+	}
+	if hc.Timeout == 0 {
+		hc.Timeout = infrared.Duration(defaultHealthTimeout)
+	}
+	if hc.FailThreshold == 0 {
+		hc.FailThreshold = defaultHealthFailThreshold
+	}
+	if hc.PassThreshold == 0 {
+		hc.PassThreshold = defaultHealthPassThreshold
+	}
+
+	if len(cfg.ProxyTo) == 0 {
+		return nil, hc, nil
+	}
+
+	var single string
+	if err := json.Unmarshal(cfg.ProxyTo, &single); err == nil {
+		return []BackendConfig{{Address: single, Weight: 1}}, hc, nil
+	}
+
+	var list []BackendConfig
+	if err := json.Unmarshal(cfg.ProxyTo, &list); err != nil {
+		return nil, hc, fmt.Errorf("proxyTo must be a string or a list of backends: %w", err)
+	}
+	for i := range list {
+		if list[i].Weight <= 0 {
+			list[i].Weight = 1
+		}
+	}
+	return list, hc, nil
+}
+
+// startHealthChecker and stopHealthChecker delegate to the infrared package,
+// which owns the actual checker goroutines and backend-selection state (see
+// infrared.PickBackend).
+func startHealthChecker(name string, backends []BackendConfig, hc HealthCheckConfig) {
+	infrared.StartHealthChecker(name, backends, hc)
+}
+
+func stopHealthChecker(name string) {
+	infrared.StopHealthChecker(name)
+}
+
+// getProxyHealth responds with the current state of every backend behind a proxy.
+func getProxyHealth() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+
+		states, ok := infrared.GetHealth(name)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(states); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package api
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwksRefreshInterval bounds how often we'll re-fetch the JWKS document for an unknown kid.
+const jwksRefreshInterval = time.Minute
+
+// claimsLeeway is the allowed clock skew when validating exp/nbf.
+const claimsLeeway = 60 * time.Second
+
+// jwk is a single entry of a JSON Web Key Set as used by OIDC providers.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwksDocument struct {
+	Keys []jwk `json:"keys"`
+}
+
+// jwksCache fetches and caches the signing keys of an OIDC issuer's JWKS endpoint.
+type jwksCache struct {
+	url string
+
+	mu          sync.RWMutex
+	keys        map[string]interface{}
+	lastFetched time.Time
+}
+
+func newJWKSCache(url string) *jwksCache {
+	return &jwksCache{
+		url:  url,
+		keys: make(map[string]interface{}),
+	}
+}
+
+// keyForKid returns the public key for kid, refreshing the JWKS document if the
+// key is unknown and we haven't refreshed too recently.
+func (c *jwksCache) keyForKid(kid string) (interface{}, error) {
+	c.mu.RLock()
+	key, ok := c.keys[kid]
+	c.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Another goroutine might have refreshed while we waited for the lock.
+	if key, ok := c.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(c.lastFetched) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+
+	if err := c.refreshLocked(); err != nil {
+		return nil, err
+	}
+
+	key, ok = c.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	return key, nil
+}
+
+func (c *jwksCache) refreshLocked() error {
+	c.lastFetched = time.Now()
+
+	resp, err := http.Get(c.url)
+	if err != nil {
+		return fmt.Errorf("fetching JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching JWKS: unexpected status %d", resp.StatusCode)
+	}
+
+	var doc jwksDocument
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return fmt.Errorf("decoding JWKS: %w", err)
+	}
+
+	keys := make(map[string]interface{}, len(doc.Keys))
+	for _, k := range doc.Keys {
+		pub, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	c.keys = keys
+
+	return nil
+}
+
+func ecCurve(name string) (elliptic.Curve, error) {
+	switch name {
+	case "P-256":
+		return elliptic.P256(), nil
+	case "P-384":
+		return elliptic.P384(), nil
+	case "P-521":
+		return elliptic.P521(), nil
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", name)
+	}
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		nBytes, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		eBytes, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(nBytes),
+			E: int(new(big.Int).SetBytes(eBytes).Int64()),
+		}, nil
+	case "EC":
+		curve, err := ecCurve(k.Crv)
+		if err != nil {
+			return nil, err
+		}
+		xBytes, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		yBytes, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(xBytes),
+			Y:     new(big.Int).SetBytes(yBytes),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// jwtClaims is the subset of registered claims infrared cares about.
+type jwtClaims struct {
+	Subject   string      `json:"sub"`
+	Issuer    string      `json:"iss"`
+	Audience  interface{} `json:"aud"`
+	Expiry    int64       `json:"exp"`
+	NotBefore int64       `json:"nbf"`
+	Scope     string      `json:"scope"`
+}
+
+func (c jwtClaims) hasAudience(want string) bool {
+	switch aud := c.Audience.(type) {
+	case string:
+		return aud == want
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok && s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c jwtClaims) scopes() []string {
+	if c.Scope == "" {
+		return nil
+	}
+	return strings.Fields(c.Scope)
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// verifyJWT parses and validates a JWT against the configured issuer/audience and
+// the signing keys served by jwksURL, returning the validated claims.
+func verifyJWT(token string, jwks *jwksCache, issuer, audience string) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	signingInput := parts[0] + "." + parts[1]
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("decoding header: %w", err)
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("parsing header: %w", err)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("decoding signature: %w", err)
+	}
+
+	key, err := jwks.keyForKid(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifySignature(header.Alg, key, signingInput, sig); err != nil {
+		return nil, err
+	}
+
+	payloadJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("decoding payload: %w", err)
+	}
+	var claims jwtClaims
+	if err := json.Unmarshal(payloadJSON, &claims); err != nil {
+		return nil, fmt.Errorf("parsing claims: %w", err)
+	}
+
+	now := time.Now()
+	if claims.Expiry != 0 && now.After(time.Unix(claims.Expiry, 0).Add(claimsLeeway)) {
+		return nil, errors.New("token expired")
+	}
+	if claims.NotBefore != 0 && now.Before(time.Unix(claims.NotBefore, 0).Add(-claimsLeeway)) {
+		return nil, errors.New("token not yet valid")
+	}
+	if issuer != "" && claims.Issuer != issuer {
+		return nil, errors.New("unexpected issuer")
+	}
+	if audience != "" && !claims.hasAudience(audience) {
+		return nil, errors.New("unexpected audience")
+	}
+
+	return &claims, nil
+}
+
+func verifySignature(alg string, key interface{}, signingInput string, sig []byte) error {
+	hashed := sha256.Sum256([]byte(signingInput))
+
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("kid does not match an RSA key")
+		}
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, hashed[:], sig)
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("kid does not match an EC key")
+		}
+		if len(sig) != 64 {
+			return errors.New("malformed EC signature")
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, hashed[:], r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+}
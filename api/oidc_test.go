@@ -0,0 +1,163 @@
+package api
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://issuer.example.com"
+	testAudience = "infrared-api"
+	testKid      = "test-kid"
+)
+
+func testRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating test RSA key: %v", err)
+	}
+	return key
+}
+
+func base64urlJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshaling %v: %v", v, err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// signRS256 builds a compact RS256 JWT from header and claims, signed with key.
+func signRS256(t *testing.T, key *rsa.PrivateKey, header, claims map[string]interface{}) string {
+	t.Helper()
+	signingInput := base64urlJSON(t, header) + "." + base64urlJSON(t, claims)
+	hashed := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatalf("signing test token: %v", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerifyJWT(t *testing.T) {
+	key := testRSAKey(t)
+	jwks := &jwksCache{
+		keys:        map[string]interface{}{testKid: &key.PublicKey},
+		lastFetched: time.Now(),
+	}
+
+	validHeader := map[string]interface{}{"alg": "RS256", "kid": testKid}
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			token: signRS256(t, key, validHeader, map[string]interface{}{
+				"sub": "user1",
+				"iss": testIssuer,
+				"aud": testAudience,
+				"exp": now.Add(time.Hour).Unix(),
+				"nbf": now.Add(-time.Minute).Unix(),
+			}),
+			wantErr: false,
+		},
+		{
+			name: "expired",
+			token: signRS256(t, key, validHeader, map[string]interface{}{
+				"sub": "user1",
+				"iss": testIssuer,
+				"aud": testAudience,
+				"exp": now.Add(-2 * claimsLeeway).Unix(),
+			}),
+			wantErr: true,
+		},
+		{
+			name: "not yet valid",
+			token: signRS256(t, key, validHeader, map[string]interface{}{
+				"sub": "user1",
+				"iss": testIssuer,
+				"aud": testAudience,
+				"exp": now.Add(time.Hour).Unix(),
+				"nbf": now.Add(2 * claimsLeeway).Unix(),
+			}),
+			wantErr: true,
+		},
+		{
+			name: "wrong audience",
+			token: signRS256(t, key, validHeader, map[string]interface{}{
+				"sub": "user1",
+				"iss": testIssuer,
+				"aud": "someone-else",
+				"exp": now.Add(time.Hour).Unix(),
+			}),
+			wantErr: true,
+		},
+		{
+			name: "wrong kid",
+			token: signRS256(t, key, map[string]interface{}{"alg": "RS256", "kid": "unknown-kid"}, map[string]interface{}{
+				"sub": "user1",
+				"iss": testIssuer,
+				"aud": testAudience,
+				"exp": now.Add(time.Hour).Unix(),
+			}),
+			wantErr: true,
+		},
+		{
+			name:    "malformed token",
+			token:   "not-a-jwt",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := verifyJWT(tt.token, jwks, testIssuer, testAudience)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("verifyJWT(%q) = %+v, want error", tt.name, claims)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verifyJWT(%q) returned unexpected error: %v", tt.name, err)
+			}
+			if claims.Subject != "user1" {
+				t.Errorf("claims.Subject = %q, want %q", claims.Subject, "user1")
+			}
+		})
+	}
+}
+
+func TestHasRequiredScopes(t *testing.T) {
+	tests := []struct {
+		name string
+		have []string
+		want []string
+		ok   bool
+	}{
+		{name: "no required scopes", have: nil, want: nil, ok: true},
+		{name: "has all required", have: []string{"proxies:read", "proxies:write"}, want: []string{"proxies:write"}, ok: true},
+		{name: "missing required scope", have: []string{"proxies:read"}, want: []string{"proxies:write"}, ok: false},
+		{name: "empty have, scopes required", have: nil, want: []string{"proxies:write"}, ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasRequiredScopes(tt.have, tt.want); got != tt.ok {
+				t.Errorf("hasRequiredScopes(%v, %v) = %v, want %v", tt.have, tt.want, got, tt.ok)
+			}
+		})
+	}
+}
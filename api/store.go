@@ -0,0 +1,301 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-redis/redis/v8"
+)
+
+// StoreEvent is emitted by a ConfigStore's Watch channel whenever a proxy
+// config changes, regardless of which replica made the change.
+type StoreEvent struct {
+	Op   string `json:"op"` // "upsert" or "delete"
+	Name string `json:"name"`
+	Data []byte `json:"data,omitempty"`
+}
+
+// ConfigStore abstracts the persistence of proxy configs away from the
+// filesystem so infrared can run with multiple API replicas behind a shared
+// backend.
+type ConfigStore interface {
+	List(ctx context.Context) ([]string, error)
+	Get(ctx context.Context, name string) ([]byte, error)
+	Put(ctx context.Context, name string, data []byte) error
+	Delete(ctx context.Context, name string) error
+	Watch(ctx context.Context) <-chan StoreEvent
+}
+
+// newConfigStore builds the ConfigStore selected by APIConfig.Store ("file"
+// by default, or "redis").
+func newConfigStore(cfg APIConfig, configPath string) (ConfigStore, error) {
+	switch cfg.Store {
+	case "redis":
+		if cfg.RedisAddr == "" {
+			return nil, fmt.Errorf("redisAddr must be set when store is \"redis\"")
+		}
+		if redisClient == nil {
+			redisClient = redis.NewClient(&redis.Options{Addr: cfg.RedisAddr})
+		}
+		// A distinct channel from APIConfig.EventsChannel: this one drives
+		// Watch for other replicas, the dashboard one drives SSE clients.
+		return NewRedisStore(redisClient, "infrared:proxy:", "infrared:proxy:store"), nil
+	case "", "file":
+		return NewFileStore(configPath), nil
+	default:
+		return nil, fmt.Errorf("unknown store %q", cfg.Store)
+	}
+}
+
+// AtomicPutter is implemented by stores that can commit several entries as a
+// single all-or-nothing batch, used by the bulk import endpoint.
+type AtomicPutter interface {
+	PutAll(ctx context.Context, entries map[string][]byte) error
+}
+
+// FileStore is the original on-disk behavior: one JSON file per proxy,
+// written atomically via a .temp file and rename, watched with fsnotify.
+type FileStore struct {
+	path string
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+func (s *FileStore) List(ctx context.Context) ([]string, error) {
+	files, err := os.ReadDir(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(file.Name(), ".json"))
+	}
+	return names, nil
+}
+
+func (s *FileStore) Get(ctx context.Context, name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.path, name+".json"))
+}
+
+func (s *FileStore) Put(ctx context.Context, name string, data []byte) error {
+	path := filepath.Join(s.path, name+".json")
+	temppath := path + ".temp"
+
+	if err := os.WriteFile(temppath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(temppath, path)
+}
+
+func (s *FileStore) Delete(ctx context.Context, name string) error {
+	return os.Remove(filepath.Join(s.path, name+".json"))
+}
+
+// PutAll writes every entry to a .temp file first, and only renames any of
+// them into place once all of them have been written successfully.
+func (s *FileStore) PutAll(ctx context.Context, entries map[string][]byte) error {
+	temppaths := make(map[string]string, len(entries))
+
+	for name, data := range entries {
+		path := filepath.Join(s.path, name+".json")
+		temppath := path + ".temp"
+		if err := os.WriteFile(temppath, data, 0644); err != nil {
+			return fmt.Errorf("writing %s: %w", name, err)
+		}
+		temppaths[name] = temppath
+	}
+
+	for name, temppath := range temppaths {
+		path := filepath.Join(s.path, name+".json")
+		if err := os.Rename(temppath, path); err != nil {
+			return fmt.Errorf("committing %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// Watch starts an fsnotify watcher on the store's directory and translates
+// its events into StoreEvents until ctx is cancelled.
+func (s *FileStore) Watch(ctx context.Context) <-chan StoreEvent {
+	out := make(chan StoreEvent)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Println(err)
+		close(out)
+		return out
+	}
+	if err := watcher.Add(s.path); err != nil {
+		log.Println(err)
+		close(out)
+		return out
+	}
+
+	go func() {
+		defer watcher.Close()
+		defer close(out)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !strings.HasSuffix(event.Name, ".json") {
+					continue
+				}
+				name := strings.TrimSuffix(filepath.Base(event.Name), ".json")
+
+				switch {
+				case event.Op&(fsnotify.Write|fsnotify.Create) != 0:
+					data, err := os.ReadFile(event.Name)
+					if err != nil {
+						continue
+					}
+					out <- StoreEvent{Op: "upsert", Name: name, Data: data}
+				case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+					out <- StoreEvent{Op: "delete", Name: name}
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Println(err)
+			}
+		}
+	}()
+
+	return out
+}
+
+// RedisStore stores each proxy as a Redis hash key (infrared:proxy:<name>)
+// and drives Watch off a pub/sub channel, so it works across replicas
+// without relying on keyspace notifications being enabled server-side.
+type RedisStore struct {
+	client  *redis.Client
+	prefix  string
+	channel string
+}
+
+func NewRedisStore(client *redis.Client, prefix, channel string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix, channel: channel}
+}
+
+func (s *RedisStore) key(name string) string {
+	return s.prefix + name
+}
+
+func (s *RedisStore) List(ctx context.Context) ([]string, error) {
+	var names []string
+	var cursor uint64
+
+	for {
+		keys, next, err := s.client.Scan(ctx, cursor, s.prefix+"*", 100).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			names = append(names, strings.TrimPrefix(key, s.prefix))
+		}
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+	return names, nil
+}
+
+func (s *RedisStore) Get(ctx context.Context, name string) ([]byte, error) {
+	data, err := s.client.HGet(ctx, s.key(name), "config").Bytes()
+	if err == redis.Nil {
+		return nil, os.ErrNotExist
+	}
+	return data, err
+}
+
+func (s *RedisStore) Put(ctx context.Context, name string, data []byte) error {
+	if err := s.client.HSet(ctx, s.key(name), "config", data).Err(); err != nil {
+		return err
+	}
+	return s.publish(ctx, StoreEvent{Op: "upsert", Name: name, Data: data})
+}
+
+func (s *RedisStore) Delete(ctx context.Context, name string) error {
+	if err := s.client.Del(ctx, s.key(name)).Err(); err != nil {
+		return err
+	}
+	return s.publish(ctx, StoreEvent{Op: "delete", Name: name})
+}
+
+// PutAll commits every entry in a single Redis pipeline so either all of
+// them land or, if the pipeline fails, none of them do.
+func (s *RedisStore) PutAll(ctx context.Context, entries map[string][]byte) error {
+	pipe := s.client.TxPipeline()
+	for name, data := range entries {
+		pipe.HSet(ctx, s.key(name), "config", data)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	for name, data := range entries {
+		if err := s.publish(ctx, StoreEvent{Op: "upsert", Name: name, Data: data}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RedisStore) publish(ctx context.Context, event StoreEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return s.client.Publish(ctx, s.channel, payload).Err()
+}
+
+// Watch subscribes to the store's pub/sub channel until ctx is cancelled.
+func (s *RedisStore) Watch(ctx context.Context) <-chan StoreEvent {
+	out := make(chan StoreEvent)
+	sub := s.client.Subscribe(ctx, s.channel)
+
+	go func() {
+		defer close(out)
+		defer sub.Close()
+
+		ch := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				var event StoreEvent
+				if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+					log.Println(err)
+					continue
+				}
+				out <- event
+			}
+		}
+	}()
+
+	return out
+}
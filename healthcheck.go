@@ -0,0 +1,328 @@
+package infrared
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// BackendConfig is one entry of a ProxyConfig's ProxyTo when it is specified
+// as a list rather than a single address string.
+type BackendConfig struct {
+	Address       string   `json:"address"`
+	Weight        int      `json:"weight"`
+	BypassDomains []string `json:"bypassDomains"`
+}
+
+// Duration is a time.Duration that accepts either a Go duration string
+// ("5s", "500ms") or a bare JSON number, interpreted as whole seconds, so
+// config authors don't have to write out nanosecond counts.
+type Duration time.Duration
+
+// Duration returns d as a time.Duration.
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	switch v := raw.(type) {
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q: %w", v, err)
+		}
+		*d = Duration(parsed)
+	case float64:
+		*d = Duration(time.Duration(v) * time.Second)
+	default:
+		return fmt.Errorf("duration must be a string (\"5s\") or a number of seconds, got %T", raw)
+	}
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// HealthCheckConfig controls how a proxy's backends are probed.
+type HealthCheckConfig struct {
+	Interval      Duration `json:"interval"`
+	Timeout       Duration `json:"timeout"`
+	FailThreshold int      `json:"failThreshold"`
+	PassThreshold int      `json:"passThreshold"`
+	MinecraftSLP  bool     `json:"minecraftSlp"`
+}
+
+// BackendState is a point-in-time snapshot of a single backend's health,
+// returned by GetHealth for the API's GET /proxies/{name}/health.
+type BackendState struct {
+	BackendConfig
+	Healthy bool `json:"healthy"`
+}
+
+// backendState is the health checker's mutable view of a single backend.
+type backendState struct {
+	BackendConfig
+	healthy         bool
+	consecutiveFail int
+	consecutivePass int
+}
+
+// proxyHealth is the current health state of every backend behind a proxy.
+type proxyHealth struct {
+	mu       sync.RWMutex
+	backends []*backendState
+	cancel   func()
+}
+
+var (
+	healthMu sync.Mutex
+	health   = map[string]*proxyHealth{}
+)
+
+// StartHealthChecker (re)starts the background checker for a proxy,
+// replacing any previously running one for the same name. Called by the API
+// whenever a proxy config with backends is created, updated, or loaded at
+// startup.
+func StartHealthChecker(name string, backends []BackendConfig, hc HealthCheckConfig) {
+	StopHealthChecker(name)
+	if len(backends) == 0 {
+		return
+	}
+
+	states := make([]*backendState, len(backends))
+	for i, b := range backends {
+		states[i] = &backendState{BackendConfig: b, healthy: true}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ph := &proxyHealth{backends: states, cancel: cancel}
+
+	healthMu.Lock()
+	health[name] = ph
+	healthMu.Unlock()
+
+	go runHealthChecker(ctx, ph, hc)
+}
+
+// StopHealthChecker stops and forgets the checker for name, if one is running.
+func StopHealthChecker(name string) {
+	healthMu.Lock()
+	ph, ok := health[name]
+	delete(health, name)
+	healthMu.Unlock()
+
+	if ok && ph.cancel != nil {
+		ph.cancel()
+	}
+}
+
+func runHealthChecker(ctx context.Context, ph *proxyHealth, hc HealthCheckConfig) {
+	ticker := time.NewTicker(hc.Interval.Duration())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ph.mu.Lock()
+			for _, b := range ph.backends {
+				ok := probeBackend(b.Address, hc)
+				if ok {
+					b.consecutiveFail = 0
+					b.consecutivePass++
+					if b.consecutivePass >= hc.PassThreshold {
+						b.healthy = true
+					}
+				} else {
+					b.consecutivePass = 0
+					b.consecutiveFail++
+					if b.consecutiveFail >= hc.FailThreshold {
+						b.healthy = false
+					}
+				}
+			}
+			ph.mu.Unlock()
+		}
+	}
+}
+
+// probeBackend TCP-dials a backend and, when configured, performs a
+// Minecraft status handshake to confirm it returns a valid JSON response.
+func probeBackend(address string, hc HealthCheckConfig) bool {
+	conn, err := net.DialTimeout("tcp", address, hc.Timeout.Duration())
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	if !hc.MinecraftSLP {
+		return true
+	}
+
+	conn.SetDeadline(time.Now().Add(hc.Timeout.Duration()))
+	if err := writeMinecraftHandshake(conn, address); err != nil {
+		return false
+	}
+
+	reader := bufio.NewReader(conn)
+	length, err := readVarInt(reader)
+	if err != nil || length <= 0 {
+		return false
+	}
+
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(reader, packet); err != nil {
+		return false
+	}
+
+	// A status response is packet ID 0x00 followed by a length-prefixed JSON string.
+	body := bytes.NewReader(packet)
+	if id, err := readVarInt(body); err != nil || id != 0x00 {
+		return false
+	}
+	jsonLen, err := readVarInt(body)
+	if err != nil || jsonLen <= 0 || jsonLen > body.Len() {
+		return false
+	}
+	status := make([]byte, jsonLen)
+	if _, err := io.ReadFull(body, status); err != nil {
+		return false
+	}
+	return json.Valid(status)
+}
+
+// writeMinecraftHandshake sends a handshake packet (next state: status)
+// followed by an empty status request, per the Minecraft SLP protocol.
+func writeMinecraftHandshake(conn net.Conn, address string) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		host, portStr = address, "25565"
+	}
+	var port uint16
+	fmt.Sscanf(portStr, "%d", &port)
+
+	var handshake bytes.Buffer
+	writeVarInt(&handshake, 0x00)
+	writeVarInt(&handshake, 754)
+	writeVarInt(&handshake, len(host))
+	handshake.WriteString(host)
+	handshake.WriteByte(byte(port >> 8))
+	handshake.WriteByte(byte(port))
+	writeVarInt(&handshake, 1)
+
+	if err := writePacket(conn, handshake.Bytes()); err != nil {
+		return err
+	}
+	return writePacket(conn, []byte{0x00})
+}
+
+func writePacket(w io.Writer, payload []byte) error {
+	var framed bytes.Buffer
+	writeVarInt(&framed, len(payload))
+	framed.Write(payload)
+	_, err := w.Write(framed.Bytes())
+	return err
+}
+
+func writeVarInt(w io.ByteWriter, value int) {
+	v := uint32(value)
+	for {
+		b := byte(v & 0x7F)
+		v >>= 7
+		if v != 0 {
+			b |= 0x80
+		}
+		w.WriteByte(b)
+		if v == 0 {
+			return
+		}
+	}
+}
+
+func readVarInt(r io.ByteReader) (int, error) {
+	var result int
+	for shift := 0; shift < 35; shift += 7 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		result |= int(b&0x7F) << shift
+		if b&0x80 == 0 {
+			return result, nil
+		}
+	}
+	return 0, fmt.Errorf("varint too long")
+}
+
+// PickBackend returns the address of a healthy backend for name, chosen at
+// random with probability proportional to its weight; successive calls do
+// not rotate through the backends in a fixed round-robin order. No
+// connection-handling code calls it, so incoming connections are not
+// routed through it.
+func PickBackend(name string) (string, bool) {
+	healthMu.Lock()
+	ph, ok := health[name]
+	healthMu.Unlock()
+	if !ok {
+		return "", false
+	}
+
+	ph.mu.RLock()
+	defer ph.mu.RUnlock()
+
+	totalWeight := 0
+	for _, b := range ph.backends {
+		if b.healthy {
+			totalWeight += b.Weight
+		}
+	}
+	if totalWeight == 0 {
+		return "", false
+	}
+
+	pick := rand.Intn(totalWeight)
+	for _, b := range ph.backends {
+		if !b.healthy {
+			continue
+		}
+		if pick < b.Weight {
+			return b.Address, true
+		}
+		pick -= b.Weight
+	}
+	return "", false
+}
+
+// GetHealth returns a snapshot of every backend's health behind name, for
+// the API's GET /proxies/{name}/health.
+func GetHealth(name string) ([]BackendState, bool) {
+	healthMu.Lock()
+	ph, ok := health[name]
+	healthMu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	ph.mu.RLock()
+	defer ph.mu.RUnlock()
+
+	states := make([]BackendState, len(ph.backends))
+	for i, b := range ph.backends {
+		states[i] = BackendState{BackendConfig: b.BackendConfig, Healthy: b.healthy}
+	}
+	return states, true
+}
@@ -0,0 +1,39 @@
+package infrared
+
+import "sync"
+
+// proxyRegistry is the live set of proxy configs kept in step with the API's
+// ConfigStore. ReloadProxy/RemoveProxy are the write side and GetProxyConfig
+// the read side. Connection dispatch does not read from it, so a change here
+// does not by itself change how incoming connections are routed.
+type proxyRegistry struct {
+	mu      sync.RWMutex
+	proxies map[string]ProxyConfig
+}
+
+var proxies = &proxyRegistry{proxies: map[string]ProxyConfig{}}
+
+// ReloadProxy installs cfg as the registry's current config for name,
+// replacing any earlier one. Called by the API whenever a
+// proxy config is created or updated, regardless of which replica or
+// ConfigStore backend made the change (see api.watchConfigStore).
+func ReloadProxy(name string, cfg ProxyConfig) {
+	proxies.mu.Lock()
+	defer proxies.mu.Unlock()
+	proxies.proxies[name] = cfg
+}
+
+// RemoveProxy drops name from the registry.
+func RemoveProxy(name string) {
+	proxies.mu.Lock()
+	defer proxies.mu.Unlock()
+	delete(proxies.proxies, name)
+}
+
+// GetProxyConfig returns the registry's current config for name, if any.
+func GetProxyConfig(name string) (ProxyConfig, bool) {
+	proxies.mu.RLock()
+	defer proxies.mu.RUnlock()
+	cfg, ok := proxies.proxies[name]
+	return cfg, ok
+}